@@ -1,36 +1,41 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/quikly/goshorty/storage"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
+	"log"
 	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type Settings struct {
-	RedisUrl       string
-	RedisPrefix    string
 	RestrictDomain string
 	Redirect404    string
 	UrlLength      int
+	Regex          string
 }
 
 type ApiAddRequest struct {
 	LongUrl string
 }
 
-func ApiAddHandler(resp http.ResponseWriter, req *http.Request) {
-	body, err := ioutil.ReadAll(req.Body);
+func ApiAddHandler(resp http.ResponseWriter, req *http.Request) error {
+	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		RenderJsonError(resp, req, err.Error(), http.StatusInternalServerError)
-		return
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	}
 
 	var message ApiAddRequest
@@ -39,94 +44,113 @@ func ApiAddHandler(resp http.ResponseWriter, req *http.Request) {
 		if err := dec.Decode(&message); err == io.EOF {
 			break
 		} else if err != nil {
-			RenderJsonError(resp, req, err.Error(), http.StatusBadRequest)
-			return
+			return httpErrorf(http.StatusBadRequest, "%s", err)
 		}
 	}
 
 	if message.LongUrl == "" {
-		RenderJsonError(resp, req, "No URL to shorten", http.StatusBadRequest)
-		return
+		return httpErrorf(http.StatusBadRequest, "No URL to shorten")
 	}
 
-	gosUrl, err := NewUrl(message.LongUrl)
+	opts := storage.CreateOptions{}
+	if token := tokenFromContext(req.Context()); token != nil {
+		opts.Owner = token.Token
+	}
+
+	gosUrl, err := NewUrlWithOptions(message.LongUrl, opts)
 	if err != nil {
-		RenderJsonError(resp, req, err.Error(), http.StatusBadRequest)
-		return
+		return httpErrorf(http.StatusBadRequest, "%s", err)
 	}
 
 	shortUrl, err := router.Get("redirect").URL("id", gosUrl.Id)
 	if err != nil {
-		RenderJsonError(resp, req, err.Error(), http.StatusBadRequest)
-		return
+		return httpErrorf(http.StatusBadRequest, "%s", err)
 	}
 
 	json := fmt.Sprintf("{\"id\":\"http://%s%s\",\"longUrl\":\"%s\"}", req.Host, shortUrl, gosUrl.Destination)
 	resp.Write([]byte(json))
+	return nil
 }
 
-func AddHandler(resp http.ResponseWriter, req *http.Request) {
-	gosUrl, err := NewUrl(req.FormValue("url"))
+func AddHandler(resp http.ResponseWriter, req *http.Request) error {
+	opts := storage.CreateOptions{}
+	if token := tokenFromContext(req.Context()); token != nil {
+		opts.Owner = token.Token
+	}
+
+	gosUrl, err := NewUrlWithOptions(req.FormValue("url"), opts)
 	if err != nil {
 		Render(resp, req, "home", map[string]string{"error": err.Error()})
-		return
+		return nil
 	}
 
 	statsUrl, err := router.Get("stats").URL("id", gosUrl.Id)
 	if err != nil {
-		RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-		return
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	}
 	http.Redirect(resp, req, statsUrl.String(), http.StatusFound)
+	return nil
 }
 
-func RedirectHandler(resp http.ResponseWriter, req *http.Request) {
+func RedirectHandler(resp http.ResponseWriter, req *http.Request) error {
 	vars := mux.Vars(req)
 	gosUrl, err := GetUrl(vars["id"])
 	if err != nil {
-		RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-		return
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	} else if gosUrl == nil {
+		redirectsNotFound.Inc()
 		if settings.Redirect404 != "" {
 			originalUrl, err := router.Get("redirect").URL("id", vars["id"])
 			if err != nil {
-				RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-				return
+				return httpErrorf(http.StatusInternalServerError, "%s", err)
 			}
 			url404 := strings.Replace(settings.Redirect404, "$gosURL", url.QueryEscape(fmt.Sprintf("http://%s%s", req.Host, originalUrl.String())), 1)
 			http.Redirect(resp, req, url404, http.StatusTemporaryRedirect)
-			return
+			return nil
 		}
-		RenderError(resp, req, "No URL was found with that goshorty code", http.StatusNotFound)
-		return
+		return httpErrorf(http.StatusNotFound, "No URL was found with that goshorty code")
+	} else if gosUrl.Expired() {
+		return httpErrorf(http.StatusGone, "That goshorty code has expired")
 	}
 
+	destinationHost := gosUrl.Destination
+	if u, err := url.Parse(gosUrl.Destination); err == nil {
+		destinationHost = u.Host
+	}
+	annotateSpan(req.Context(), gosUrl.Id, destinationHost)
+
 	request, _ := requestParser.Parse(req)
-	go gosUrl.Hit(request)
+	country := ""
+	if request != nil {
+		country = request.Country
+	}
+	redirectsServed.WithLabelValues(shortcodeClass(gosUrl.Id), country).Inc()
+
+	hitCtx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(req.Context()))
+	enqueueHit(hitCtx, gosUrl, request)
+
 	http.Redirect(resp, req, gosUrl.Destination, http.StatusMovedPermanently)
+	return nil
 }
 
-func StatHandler(resp http.ResponseWriter, req *http.Request) {
+func StatHandler(resp http.ResponseWriter, req *http.Request) error {
 	vars := mux.Vars(req)
 
 	if req.Header.Get("X-Requested-With") == "" {
 		statsUrl, err := router.Get("stats").URL("id", vars["id"])
 		if err != nil {
-			RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-			return
+			return httpErrorf(http.StatusInternalServerError, "%s", err)
 		}
 
 		http.Redirect(resp, req, statsUrl.String(), http.StatusFound)
-		return
+		return nil
 	}
 
 	gosUrl, err := GetUrl(vars["id"])
 	if err != nil {
-		RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-		return
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	} else if gosUrl == nil {
-		RenderError(resp, req, "No URL was found with that goshorty code", http.StatusNotFound)
-		return
+		return httpErrorf(http.StatusNotFound, "No URL was found with that goshorty code")
 	}
 
 	var body []byte
@@ -145,28 +169,26 @@ func StatHandler(resp http.ResponseWriter, req *http.Request) {
 	}
 
 	if err != nil {
-		body = []byte(fmt.Sprintf("{\"error\":\"%s\"}", err.Error()))
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	}
 
 	resp.Header().Set("Content-Type", "application/json")
 	resp.Write(body)
+	return nil
 }
 
-func StatsHandler(resp http.ResponseWriter, req *http.Request) {
+func StatsHandler(resp http.ResponseWriter, req *http.Request) error {
 	vars := mux.Vars(req)
 	gosUrl, err := GetUrl(vars["id"])
 	if err != nil {
-		RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-		return
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	} else if gosUrl == nil {
-		RenderError(resp, req, "No URL was found with that goshorty code", http.StatusNotFound)
-		return
+		return httpErrorf(http.StatusNotFound, "No URL was found with that goshorty code")
 	}
 
 	hits, err := gosUrl.Hits()
 	if err != nil {
-		RenderError(resp, req, err.Error(), http.StatusInternalServerError)
-		return
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
 	}
 
 	Render(resp, req, "stats", map[string]string{
@@ -175,10 +197,12 @@ func StatsHandler(resp http.ResponseWriter, req *http.Request) {
 		"when": relativeTime(time.Now().Sub(gosUrl.Created)),
 		"hits": fmt.Sprintf("%d", hits),
 	})
+	return nil
 }
 
-func HomeHandler(resp http.ResponseWriter, req *http.Request) {
+func HomeHandler(resp http.ResponseWriter, req *http.Request) error {
 	Render(resp, req, "home", nil)
+	return nil
 }
 
 func relativeTime(duration time.Duration) string {
@@ -216,25 +240,36 @@ var (
 	router        = mux.NewRouter()
 	settings      = new(Settings)
 	requestParser *RequestParser
+	store         storage.Store
 )
 
 func main() {
 	var (
-		geoDb       string
-		redisUrl    string
-		redisPrefix string
-		regex       string
-		port        int
+		geoDb           string
+		storageUrl      string
+		regex           string
+		trustedProxyCs  string
+		tokensFile      string
+		otlpEndpoint    string
+		metricsAddr     string
+		shutdownTimeout time.Duration
+		hitDrainTimeout time.Duration
+		port            int
 	)
 
-	flag.StringVar(&redisUrl, "redis_url", "", "Redis url (leave empty for localhost)")
-	flag.StringVar(&redisPrefix, "redis_prefix", "goshorty:", "Redis prefix to use")
+	flag.StringVar(&storageUrl, "storage", "redis://localhost:6379", "Storage backend: redis://host:port, bolt:///path/to/db or postgres://...")
 	flag.StringVar(&settings.RestrictDomain, "domain", "", "Restrict destination URLs to a single domain")
 	flag.StringVar(&settings.Redirect404, "redirect_404", "", "Restrict destination URLs to a single domain")
 	flag.IntVar(&settings.UrlLength, "length", 5, "How many characters should the short code have")
 	flag.StringVar(&regex, "regex", "[A-Za-z0-9]{%d}", "Regular expression to match route for accessing a short code. %d is replaced with <length> setting")
 	flag.IntVar(&port, "port", 8080, "Port where server is listening on")
 	flag.StringVar(&geoDb, "geo_db", "./GeoIP.dat", "Location to the MaxMind GeoIP country database file")
+	flag.StringVar(&trustedProxyCs, "trusted_proxies", "", "Comma-separated CIDRs allowed to set X-Real-IP/X-Forwarded-For")
+	flag.StringVar(&tokensFile, "tokens_file", "", "YAML or JSON file of bearer tokens authorized to create/stat/admin urls. Unset allows anonymous access")
+	flag.StringVar(&otlpEndpoint, "otlp_endpoint", "", "OTLP/HTTP collector endpoint to export traces to. Unset disables tracing")
+	flag.StringVar(&metricsAddr, "metrics_addr", "", "Address to serve /metrics on, e.g. :9090. Unset disables the Prometheus endpoint")
+	flag.DurationVar(&shutdownTimeout, "shutdown_timeout", 15*time.Second, "How long to wait for in-flight requests to finish on SIGINT/SIGTERM")
+	flag.DurationVar(&hitDrainTimeout, "hit_drain_timeout", 30*time.Second, "How long to wait for queued hits to be recorded on shutdown")
 
 	flag.Parse()
 
@@ -245,28 +280,75 @@ func main() {
 	}
 
 	regex = fmt.Sprintf(regex, settings.UrlLength)
+	settings.Regex = regex
 
-  url, err := url.Parse(redisUrl)
+	store, err = storage.New(storageUrl)
 	if err != nil {
 		panic(err)
 	}
 
-  settings.RedisUrl = url.Host
-	settings.RedisPrefix = redisPrefix
+	trustedProxies, err = parseTrustedProxies(trustedProxyCs)
+	if err != nil {
+		panic(err)
+	}
 
-	router.HandleFunc("/api/v1/url", ApiAddHandler).Methods("POST").Name("add")
-	router.HandleFunc("/add", AddHandler).Methods("POST").Name("add")
-	router.HandleFunc("/{id:"+regex+"}+/{what:(hour|day|week|month|year|all|sources)}", StatHandler).Name("stat")
-	router.HandleFunc("/{id:"+regex+"}+", StatsHandler).Name("stats")
-	router.HandleFunc("/{id:"+regex+"}", RedirectHandler).Name("redirect")
-	router.HandleFunc("/", HomeHandler).Name("home")
-	for _, dir := range []string{"css", "js", "img"} {
-		router.PathPrefix("/" + dir + "/").Handler(http.StripPrefix("/"+dir+"/", http.FileServer(http.Dir("assets/"+dir))))
+	tokens, err = LoadTokenStore(tokensFile)
+	if err != nil {
+		panic(err)
 	}
 
-	fmt.Println(fmt.Sprintf("Server is listening on port %d", port))
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), router)
+	shutdownTracing, err := setupTracing(otlpEndpoint)
 	if err != nil {
 		panic(err)
 	}
+	defer shutdownTracing(context.Background())
+
+	if metricsAddr != "" {
+		serveMetrics(metricsAddr)
+	}
+
+	router.HandleFunc("/api/v1/url", runHandler(requireScope(ScopeCreate, observeHandler("api-add", ApiAddHandler)))).Methods("POST").Name("add")
+	router.HandleFunc("/api/v1/urls", runHandler(requireScope(ScopeCreate, observeHandler("bulk-add", BulkAddHandler)))).Methods("POST").Name("bulk-add")
+	router.HandleFunc("/api/v1/urls", runHandler(rateLimited(observeHandler("list", ListUrlsHandler)))).Methods("GET").Name("list")
+	router.HandleFunc("/api/v1/url/{id:"+regex+"}", runHandler(rateLimited(observeHandler("delete", DeleteUrlHandler)))).Methods("DELETE").Name("delete")
+	router.HandleFunc("/api/v1/url/{id:"+regex+"}/hooks", runHandler(requireScope(ScopeCreate, observeHandler("add-webhook", AddWebhookHandler)))).Methods("POST").Name("add-webhook")
+	router.HandleFunc("/add", runHandler(requireScope(ScopeCreate, observeHandler("add", AddHandler)))).Methods("POST").Name("add")
+	router.HandleFunc("/{id:"+regex+"}+/{what:(hour|day|week|month|year|all|sources)}", runHandler(rateLimited(observeHandler("stat", StatHandler)))).Name("stat")
+	router.HandleFunc("/{id:"+regex+"}+", runHandler(rateLimited(observeHandler("stats", StatsHandler)))).Name("stats")
+	// /events is a long-lived SSE stream, not a request/response round
+	// trip, so it's excluded from handlerDuration along with the
+	// /healthz and /readyz probes below.
+	router.HandleFunc("/{id:"+regex+"}/events", runHandler(rateLimited(EventsHandler))).Name("events")
+	router.HandleFunc("/{id:"+regex+"}", runHandler(observeHandler("redirect", RedirectHandler))).Name("redirect")
+	router.HandleFunc("/healthz", runHandler(HealthzHandler)).Name("healthz")
+	router.HandleFunc("/readyz", runHandler(ReadyzHandler)).Name("readyz")
+	router.HandleFunc("/", runHandler(HomeHandler)).Name("home")
+	for _, dir := range []string{"css", "js", "img"} {
+		router.PathPrefix("/" + dir + "/").Handler(http.StripPrefix("/"+dir+"/", http.FileServer(http.Dir("assets/"+dir))))
+	}
+
+	startHitWorkers()
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: traceMiddleware(router)}
+
+	go func() {
+		fmt.Println(fmt.Sprintf("Server is listening on port %d", port))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server shutdown: %v", err)
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), hitDrainTimeout)
+	defer drainCancel()
+	drainHitQueue(drainCtx)
 }
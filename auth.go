@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+)
+
+// Scope is a permission a Token can be granted.
+type Scope string
+
+const (
+	ScopeCreate Scope = "create"
+	ScopeStats  Scope = "stats"
+	ScopeAdmin  Scope = "admin"
+)
+
+// Token is a single entry in the -tokens_file. Qps/Burst of zero fall
+// back to defaultQps/defaultBurst.
+type Token struct {
+	Token  string   `json:"token" yaml:"token"`
+	Scopes []string `json:"scopes" yaml:"scopes"`
+	Qps    float64  `json:"qps" yaml:"qps"`
+	Burst  int      `json:"burst" yaml:"burst"`
+}
+
+// HasScope reports whether t is allowed to perform scope. The admin
+// scope implies every other scope.
+func (t *Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if Scope(s) == scope || Scope(s) == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultQps and defaultBurst rate limit anonymous access (no tokens
+// configured, or requests with no bearer token once configured) and
+// any token that didn't set its own Qps/Burst.
+const (
+	defaultQps   = 5
+	defaultBurst = 10
+)
+
+// TokenStore authenticates bearer tokens and tracks one rate limiter
+// per token (or per client IP when unauthenticated).
+type TokenStore struct {
+	tokens map[string]*Token
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// LoadTokenStore reads tokens from path, sniffing YAML vs JSON from
+// its extension. An empty path yields an empty TokenStore, meaning
+// auth is disabled and every request is treated as anonymous.
+func LoadTokenStore(path string) (*TokenStore, error) {
+	ts := &TokenStore{tokens: map[string]*Token{}, limiters: map[string]*rate.Limiter{}}
+	if path == "" {
+		return ts, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []Token
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		err = yaml.Unmarshal(data, &tokens)
+	} else {
+		err = json.Unmarshal(data, &tokens)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		ts.tokens[tokens[i].Token] = &tokens[i]
+	}
+	return ts, nil
+}
+
+// Enabled reports whether any tokens were configured. When false,
+// every request is anonymous and always authorized.
+func (ts *TokenStore) Enabled() bool {
+	return len(ts.tokens) > 0
+}
+
+// Authorize checks req's bearer token (if any) against scope,
+// returning the matched Token (nil for anonymous access) or an
+// *httpError suitable for returning straight from a handler.
+func (ts *TokenStore) Authorize(req *http.Request, scope Scope) (*Token, error) {
+	if !ts.Enabled() {
+		return nil, nil
+	}
+
+	raw := bearerToken(req)
+	if raw == "" {
+		return nil, httpErrorf(http.StatusUnauthorized, "missing bearer token")
+	}
+
+	token, ok := ts.tokens[raw]
+	if !ok {
+		return nil, httpErrorf(http.StatusUnauthorized, "unknown token")
+	}
+	if !token.HasScope(scope) {
+		return nil, httpErrorf(http.StatusForbidden, "token lacks the %q scope", scope)
+	}
+
+	return token, nil
+}
+
+// Lookup returns the Token for a raw token value, as found on a query
+// parameter rather than the Authorization header.
+func (ts *TokenStore) Lookup(raw string) (*Token, bool) {
+	token, ok := ts.tokens[raw]
+	return token, ok
+}
+
+// Identify returns the Token req's bearer header names, or nil if it
+// has none or names one that's unknown. Unlike Authorize, an unknown
+// or missing token is not an error: callers use this to rate limit
+// read endpoints that stay open to anonymous access.
+func (ts *TokenStore) Identify(req *http.Request) *Token {
+	raw := bearerToken(req)
+	if raw == "" {
+		return nil
+	}
+	return ts.tokens[raw]
+}
+
+// Allow applies the rate limit for token (or, if nil, for req's
+// client IP) and reports whether the request may proceed.
+func (ts *TokenStore) Allow(req *http.Request, token *Token) bool {
+	key := clientIP(req)
+	qps, burst := rate.Limit(defaultQps), defaultBurst
+	if token != nil {
+		key = token.Token
+		if token.Qps > 0 {
+			qps = rate.Limit(token.Qps)
+		}
+		if token.Burst > 0 {
+			burst = token.Burst
+		}
+	}
+
+	ts.mu.Lock()
+	limiter, ok := ts.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(qps, burst)
+		ts.limiters[key] = limiter
+	}
+	ts.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+// tokens is populated in main from the -tokens_file flag.
+var tokens *TokenStore
+
+// tokenContextKey is the context.Value key requireScope stores the
+// authorized *Token under, so create handlers can attribute ownership
+// without re-parsing the bearer header.
+type tokenContextKey struct{}
+
+// contextWithToken returns ctx with token attached, for requireScope
+// to thread it down to the wrapped handler.
+func contextWithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// tokenFromContext returns the *Token requireScope authorized this
+// request against, or nil if auth is disabled or the scope allowed
+// anonymous access.
+func tokenFromContext(ctx context.Context) *Token {
+	token, _ := ctx.Value(tokenContextKey{}).(*Token)
+	return token
+}
+
+// requireScope wraps fn so it only runs once req is authorized for
+// scope and under its rate limit, returning 401/403/429 otherwise. The
+// authorized token (nil if auth is disabled) is attached to req's
+// context for fn to read via tokenFromContext.
+func requireScope(scope Scope, fn handler) handler {
+	return func(resp http.ResponseWriter, req *http.Request) error {
+		token, err := tokens.Authorize(req, scope)
+		if err != nil {
+			return err
+		}
+
+		if !tokens.Allow(req, token) {
+			resp.Header().Set("Retry-After", "1")
+			return httpErrorf(http.StatusTooManyRequests, "rate limit exceeded, try again later")
+		}
+
+		req = req.WithContext(contextWithToken(req.Context(), token))
+		return fn(resp, req)
+	}
+}
+
+// rateLimited wraps fn so it runs under the token/IP rate limit
+// without requiring authorization, for read endpoints that stay open
+// to anonymous callers even when tokens are configured.
+func rateLimited(fn handler) handler {
+	return func(resp http.ResponseWriter, req *http.Request) error {
+		if !tokens.Allow(req, tokens.Identify(req)) {
+			resp.Header().Set("Retry-After", "1")
+			return httpErrorf(http.StatusTooManyRequests, "rate limit exceeded, try again later")
+		}
+
+		return fn(resp, req)
+	}
+}
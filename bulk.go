@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/quikly/goshorty/storage"
+)
+
+// BulkAddItem is a single entry in a POST /api/v1/urls request body.
+type BulkAddItem struct {
+	LongUrl     string     `json:"longUrl"`
+	CustomAlias string     `json:"customAlias,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+// BulkAddResult reports the outcome of shortening a single
+// BulkAddItem. Status mirrors the HTTP status that endpoint would
+// have returned had it been shortened alone.
+type BulkAddResult struct {
+	Id      string `json:"id,omitempty"`
+	LongUrl string `json:"longUrl,omitempty"`
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkAddHandler shortens a JSON array of URLs in one request. Unlike
+// ApiAddHandler, a single bad item does not fail the whole batch: its
+// BulkAddResult simply carries an error and non-2xx Status.
+func BulkAddHandler(resp http.ResponseWriter, req *http.Request) error {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	}
+
+	var items []BulkAddItem
+	if err := json.Unmarshal(body, &items); err != nil {
+		return httpErrorf(http.StatusBadRequest, "%s", err)
+	}
+
+	idempotencyKey := req.Header.Get("Idempotency-Key")
+	owner := ""
+	if token := tokenFromContext(req.Context()); token != nil {
+		owner = token.Token
+	}
+	aliasRegex := regexp.MustCompile("^" + settings.Regex + "$")
+
+	results := make([]BulkAddResult, len(items))
+	for i, item := range items {
+		results[i] = shortenBulkItem(item, idempotencyKey, i, req.Host, owner, aliasRegex)
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(resp).Encode(results)
+}
+
+func shortenBulkItem(item BulkAddItem, idempotencyKey string, index int, host string, owner string, aliasRegex *regexp.Regexp) BulkAddResult {
+	if item.LongUrl == "" {
+		return BulkAddResult{Status: http.StatusBadRequest, Error: "No URL to shorten"}
+	}
+
+	idempotencyKey = indexedIdempotencyKey(idempotencyKey, index)
+	if idempotencyKey != "" {
+		if existing, err := GetIdempotentUrl(idempotencyKey); err == nil && existing != nil {
+			return BulkAddResult{Id: shortUrlFor(existing.Id, host), LongUrl: existing.Destination, Status: http.StatusOK}
+		}
+	}
+
+	opts := storage.CreateOptions{Owner: owner}
+	if item.CustomAlias != "" {
+		if !aliasRegex.MatchString(item.CustomAlias) {
+			return BulkAddResult{Status: http.StatusBadRequest, Error: "customAlias does not match the configured -regex"}
+		}
+		opts.Id = item.CustomAlias
+	}
+	if item.ExpiresAt != nil {
+		opts.ExpiresAt = *item.ExpiresAt
+	}
+
+	gosUrl, err := NewUrlWithOptions(item.LongUrl, opts)
+	if err == storage.ErrExists {
+		return BulkAddResult{Status: http.StatusConflict, Error: "that customAlias is already taken"}
+	} else if err != nil {
+		return BulkAddResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	if idempotencyKey != "" {
+		RememberIdempotent(idempotencyKey, gosUrl)
+	}
+
+	return BulkAddResult{Id: shortUrlFor(gosUrl.Id, host), LongUrl: gosUrl.Destination, Status: http.StatusCreated}
+}
+
+// indexedIdempotencyKey scopes a caller-supplied Idempotency-Key to a
+// single item in the batch, since the header applies to the whole
+// request but each item needs its own retry identity.
+func indexedIdempotencyKey(key string, index int) string {
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", key, index)
+}
+
+// shortUrlFor renders id as an absolute short URL against host, or ""
+// if the route can't be built.
+func shortUrlFor(id, host string) string {
+	shortUrl, err := router.Get("redirect").URL("id", id)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("http://%s%s", host, shortUrl)
+}
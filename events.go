@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// hitEvent is what EventsHandler streams to subscribers, one per hit.
+type hitEvent struct {
+	Ts        time.Time `json:"ts"`
+	Country   string    `json:"country"`
+	Referrer  string    `json:"referrer"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// eventSubscriberBuffer bounds how many unconsumed events a single SSE
+// connection will hold before the hub starts dropping its oldest ones,
+// so one slow reader can't hold up publishing to everyone else.
+const eventSubscriberBuffer = 32
+
+// hitHub fans out hit events to the subscribers of each shortcode.
+type hitHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan hitEvent]struct{}
+}
+
+func newHitHub() *hitHub {
+	return &hitHub{subs: map[string]map[chan hitEvent]struct{}{}}
+}
+
+// hub receives a hitEvent for every GosUrl.Hit and fans it out to the
+// id's subscribed /events connections, if any.
+var hub = newHitHub()
+
+// Subscribe registers a new buffered channel for id's events. Callers
+// must Unsubscribe when done to avoid leaking the channel.
+func (h *hitHub) Subscribe(id string) chan hitEvent {
+	ch := make(chan hitEvent, eventSubscriberBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[id] == nil {
+		h.subs[id] = map[chan hitEvent]struct{}{}
+	}
+	h.subs[id][ch] = struct{}{}
+
+	return ch
+}
+
+// Unsubscribe removes ch from id's subscribers.
+func (h *hitHub) Unsubscribe(id string, ch chan hitEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[id], ch)
+	if len(h.subs[id]) == 0 {
+		delete(h.subs, id)
+	}
+}
+
+// Publish delivers event to every subscriber of id. A subscriber whose
+// buffer is full has its oldest event dropped to make room, so a slow
+// consumer never blocks the hit it's meant to be notified about.
+func (h *hitHub) Publish(id string, event hitEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs[id] {
+		select {
+		case ch <- event:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// EventsHandler streams hits to id as Server-Sent Events, one JSON
+// object per hit, until the client disconnects.
+func EventsHandler(resp http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+
+	gosUrl, err := GetUrl(vars["id"])
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	} else if gosUrl == nil {
+		return httpErrorf(http.StatusNotFound, "No URL was found with that goshorty code")
+	}
+
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		return httpErrorf(http.StatusInternalServerError, "streaming not supported")
+	}
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := hub.Subscribe(gosUrl.Id)
+	defer hub.Unsubscribe(gosUrl.Id, ch)
+
+	for {
+		select {
+		case event := <-ch:
+			body, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(resp, "data: %s\n\n", body)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return nil
+		}
+	}
+}
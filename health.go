@@ -0,0 +1,25 @@
+package main
+
+import "net/http"
+
+// HealthzHandler is a liveness probe: if the process can answer HTTP
+// at all, it's alive.
+func HealthzHandler(resp http.ResponseWriter, req *http.Request) error {
+	resp.Write([]byte("ok"))
+	return nil
+}
+
+// ReadyzHandler is a readiness probe: it additionally pings the
+// storage backend and the GeoIP database, so a pod that can't reach
+// either is taken out of rotation.
+func ReadyzHandler(resp http.ResponseWriter, req *http.Request) error {
+	if err := store.Ping(); err != nil {
+		return httpErrorf(http.StatusServiceUnavailable, "storage: %s", err)
+	}
+	if err := requestParser.Healthy(); err != nil {
+		return httpErrorf(http.StatusServiceUnavailable, "geoip: %s", err)
+	}
+
+	resp.Write([]byte("ok"))
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// hitQueueSize bounds how many hits can be queued awaiting a worker,
+// and hitWorkers bounds how many are recorded concurrently, so a
+// redirect flood can neither spawn unbounded goroutines nor exhaust
+// storage connections.
+const (
+	hitQueueSize = 1024
+	hitWorkers   = 8
+)
+
+type hitJob struct {
+	ctx     context.Context
+	gosUrl  *GosUrl
+	request *ParsedRequest
+}
+
+var (
+	hitQueue     = make(chan hitJob, hitQueueSize)
+	hitWorkersWg sync.WaitGroup
+
+	// hitQueueMu guards draining: enqueueHit holds it for reading
+	// while it sends, so drainHitQueue can't close hitQueue out from
+	// under an in-flight send (srv.Shutdown's timeout doesn't
+	// guarantee every handler goroutine has returned).
+	hitQueueMu sync.RWMutex
+	draining   bool
+)
+
+// startHitWorkers launches the bounded pool of goroutines that record
+// hits from hitQueue, replacing the old unbounded "go gosUrl.Hit(...)"
+// spawned per redirect.
+func startHitWorkers() {
+	for i := 0; i < hitWorkers; i++ {
+		hitWorkersWg.Add(1)
+		go func() {
+			defer hitWorkersWg.Done()
+			for job := range hitQueue {
+				recordHit(job)
+			}
+		}()
+	}
+}
+
+func recordHit(job hitJob) {
+	ctx, span := tracer.Start(job.ctx, "gosUrl.Hit")
+	defer span.End()
+
+	if err := job.gosUrl.HitContext(ctx, job.request); err != nil {
+		log.Printf("recording hit for %s: %v", job.gosUrl.Id, err)
+	}
+}
+
+// enqueueHit queues request to be recorded against gosUrl by the hit
+// worker pool, blocking if the queue is momentarily full rather than
+// dropping it. Once drainHitQueue has started, it drops the hit
+// instead of sending on a queue that may already be closed.
+func enqueueHit(ctx context.Context, gosUrl *GosUrl, request *ParsedRequest) {
+	hitQueueMu.RLock()
+	defer hitQueueMu.RUnlock()
+
+	if draining {
+		log.Printf("dropping hit for %s: shutting down", gosUrl.Id)
+		return
+	}
+	hitQueue <- hitJob{ctx: ctx, gosUrl: gosUrl, request: request}
+}
+
+// drainHitQueue marks the queue as draining and closes hitQueue so the
+// worker pool can exit, then waits for every already-queued hit to be
+// recorded or ctx to expire, whichever comes first. Taking hitQueueMu
+// before closing ensures no enqueueHit call already past its draining
+// check is still sending when the channel is closed.
+func drainHitQueue(ctx context.Context) {
+	hitQueueMu.Lock()
+	draining = true
+	close(hitQueue)
+	hitQueueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		hitWorkersWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("timed out draining the hit queue")
+	}
+}
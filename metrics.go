@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	shortensCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "goshorty_shortens_created_total",
+		Help: "Number of shortcodes created via the add/bulk-add handlers.",
+	})
+
+	redirectsServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goshorty_redirects_served_total",
+		Help: "Number of redirects served, labeled by shortcode class and visitor country.",
+	}, []string{"class", "country"})
+
+	redirectsNotFound = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "goshorty_redirects_not_found_total",
+		Help: "Number of redirects requested for an unknown shortcode.",
+	})
+
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "goshorty_handler_duration_seconds",
+		Help: "Handler latency, labeled by handler name.",
+	}, []string{"handler"})
+
+	storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "goshorty_storage_duration_seconds",
+		Help: "storage.Store call latency, labeled by operation.",
+	}, []string{"operation"})
+)
+
+// shortcodeClass labels a shortcode for the redirectsServed counter:
+// "custom" if it doesn't match the configured auto-generated length,
+// "generated" otherwise.
+func shortcodeClass(id string) string {
+	if len(id) != settings.UrlLength {
+		return "custom"
+	}
+	return "generated"
+}
+
+// observeHandler times fn under the handlerDuration histogram for name.
+func observeHandler(name string, fn handler) handler {
+	return func(resp http.ResponseWriter, req *http.Request) error {
+		start := time.Now()
+		err := fn(resp, req)
+		handlerDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// observeStorage times fn under the storageDuration histogram for op,
+// returning whatever fn returns.
+func observeStorage(op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	storageDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// serveMetrics starts a standalone HTTP server exposing /metrics on
+// addr. Called from main only when -metrics_addr is set.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// maxRequestBytes caps the size of request bodies handlers will read,
+// so a single client can't exhaust memory via an unbounded POST.
+const maxRequestBytes = 1 << 20 // 1MB
+
+// httpError carries the status code a handler wants rendered to the
+// client alongside the underlying error.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// httpErrorf builds an httpError from a status and a format string.
+func httpErrorf(status int, format string, args ...interface{}) *httpError {
+	return &httpError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// handler is the signature every route in app.go is registered with.
+// Returning an error (ideally an *httpError) lets runHandler render it
+// consistently instead of each handler doing it inline.
+type handler func(resp http.ResponseWriter, req *http.Request) error
+
+// runHandler wraps fn with panic recovery, a request body size limit,
+// trusted-proxy aware client IP resolution and centralized error
+// rendering, and adapts it to http.HandlerFunc.
+func runHandler(fn handler) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(resp, req.Body, maxRequestBytes)
+		}
+		req.RemoteAddr = clientIP(req)
+
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("panic handling %s %s: %v\n%s", req.Method, req.URL.Path, r, debug.Stack())
+				renderError(resp, req, httpErrorf(http.StatusInternalServerError, "internal error"))
+			}
+		}()
+
+		if err := fn(resp, req); err != nil {
+			renderError(resp, req, err)
+		}
+	}
+}
+
+// renderError writes err to resp, rendering JSON for API/XHR clients
+// and the HTML error template for everyone else.
+func renderError(resp http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if herr, ok := err.(*httpError); ok {
+		status = herr.status
+		err = herr.err
+	}
+
+	if wantsJson(req) {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(status)
+		json.NewEncoder(resp).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp.WriteHeader(status)
+	Render(resp, req, "home", map[string]string{"error": err.Error()})
+}
+
+// wantsJson decides whether the client expects a JSON error body,
+// based on the request path and its Accept header.
+func wantsJson(req *http.Request) bool {
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		return true
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json") ||
+		req.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// trustedProxies holds the CIDR ranges allowed to set X-Real-IP or
+// X-Forwarded-For, populated from the -trusted_proxies flag.
+var trustedProxies []*net.IPNet
+
+// clientIP returns the address req should be considered to originate
+// from: the real client IP from X-Real-IP/X-Forwarded-For when the
+// immediate peer is a trusted proxy, otherwise the peer's host from
+// req.RemoteAddr with its port stripped. A trusted proxy that sends
+// neither header yields req.RemoteAddr unchanged.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if peer == nil || !isTrustedProxy(peer) {
+		return host
+	}
+
+	if real := req.Header.Get("X-Real-IP"); real != "" {
+		return real
+	}
+
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	return req.RemoteAddr
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, cidr := range trustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs, as
+// given on the -trusted_proxies flag.
+func parseTrustedProxies(csv string) ([]*net.IPNet, error) {
+	if csv == "" {
+		return nil, nil
+	}
+
+	var cidrs []*net.IPNet
+	for _, raw := range strings.Split(csv, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies: %v", err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
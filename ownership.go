@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ownedUrl is how ListUrlsHandler renders each url a token owns.
+type ownedUrl struct {
+	Id        string `json:"id"`
+	LongUrl   string `json:"longUrl"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// ListUrlsHandler lists the shortcodes owned by the token given as a
+// ?token= query parameter.
+func ListUrlsHandler(resp http.ResponseWriter, req *http.Request) error {
+	raw := req.URL.Query().Get("token")
+	if raw == "" {
+		return httpErrorf(http.StatusBadRequest, "token query parameter is required")
+	}
+
+	if tokens.Enabled() {
+		if _, ok := tokens.Lookup(raw); !ok {
+			return httpErrorf(http.StatusUnauthorized, "unknown token")
+		}
+	}
+
+	urls, err := ListUrlsByOwner(raw)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	}
+
+	owned := make([]ownedUrl, len(urls))
+	for i, u := range urls {
+		entry := ownedUrl{Id: shortUrlFor(u.Id, req.Host), LongUrl: u.Destination}
+		if !u.ExpiresAt.IsZero() {
+			entry.ExpiresAt = u.ExpiresAt.Format(http.TimeFormat)
+		}
+		owned[i] = entry
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(resp).Encode(owned)
+}
+
+// DeleteUrlHandler removes a shortcode. When tokens are configured,
+// the caller must either own it or hold the admin scope.
+func DeleteUrlHandler(resp http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+
+	gosUrl, err := GetUrl(vars["id"])
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	} else if gosUrl == nil {
+		return httpErrorf(http.StatusNotFound, "No URL was found with that goshorty code")
+	}
+
+	if tokens.Enabled() {
+		token, ok := tokens.Lookup(bearerToken(req))
+		if !ok {
+			return httpErrorf(http.StatusUnauthorized, "missing or unknown bearer token")
+		}
+		if gosUrl.Owner != token.Token && !token.HasScope(ScopeAdmin) {
+			return httpErrorf(http.StatusForbidden, "token may not delete a url it does not own")
+		}
+	}
+
+	if err := DeleteUrl(gosUrl.Id); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
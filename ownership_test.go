@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/quikly/goshorty/storage"
+	"golang.org/x/time/rate"
+)
+
+// newTestTokenStore returns a TokenStore seeded with the given tokens,
+// bypassing LoadTokenStore's file parsing.
+func newTestTokenStore(toks ...Token) *TokenStore {
+	ts := &TokenStore{tokens: map[string]*Token{}, limiters: map[string]*rate.Limiter{}}
+	for i := range toks {
+		ts.tokens[toks[i].Token] = &toks[i]
+	}
+	return ts
+}
+
+// TestDeleteUrlHandler_Ownership exercises the create-then-delete path
+// a token that created a url goes through, and confirms a different
+// token (without the admin scope) is forbidden from deleting it.
+func TestDeleteUrlHandler_Ownership(t *testing.T) {
+	u, err := url.Parse("bolt://" + t.TempDir() + "/goshorty.db")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	boltStore, err := storage.NewBoltStore(u)
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	defer boltStore.Close()
+
+	oldStore, oldSettings, oldTokens := store, settings, tokens
+	defer func() { store, settings, tokens = oldStore, oldSettings, oldTokens }()
+
+	store = boltStore
+	settings = &Settings{UrlLength: 5, Regex: "[A-Za-z0-9]{5}"}
+	tokens = newTestTokenStore(
+		Token{Token: "owner-token", Scopes: []string{"create"}},
+		Token{Token: "other-token", Scopes: []string{"create"}},
+	)
+
+	gosUrl, err := NewUrlWithOptions("http://example.com", storage.CreateOptions{Owner: "owner-token"})
+	if err != nil {
+		t.Fatalf("NewUrlWithOptions: %v", err)
+	}
+
+	deleteAs := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/url/"+gosUrl.Id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = mux.SetURLVars(req, map[string]string{"id": gosUrl.Id})
+		resp := httptest.NewRecorder()
+		if err := DeleteUrlHandler(resp, req); err != nil {
+			renderError(resp, req, err)
+		}
+		return resp
+	}
+
+	if resp := deleteAs("other-token"); resp.Code != http.StatusForbidden {
+		t.Fatalf("delete by non-owner: expected 403, got %d", resp.Code)
+	}
+
+	if resp := deleteAs("owner-token"); resp.Code != http.StatusNoContent {
+		t.Fatalf("delete by owner: expected 204, got %d", resp.Code)
+	}
+
+	if gotUrl, err := GetUrl(gosUrl.Id); err != nil {
+		t.Fatalf("GetUrl: %v", err)
+	} else if gotUrl != nil {
+		t.Fatalf("expected %q to be deleted", gosUrl.Id)
+	}
+}
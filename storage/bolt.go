@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	urlsBucket       = []byte("urls")
+	createdBucket    = []byte("created")
+	expiresBucket    = []byte("expires")
+	ownerBucket      = []byte("owner")
+	hitsBucket       = []byte("hits")
+	sourcesBucket    = []byte("sources")
+	idempotentBucket = []byte("idempotent")
+	hooksBucket      = []byte("hooks")
+)
+
+// BoltStore is an embedded, single-file driver suited to small
+// single-binary deployments that don't want to run a separate
+// database. Each shortcode's hits live in their own nested bucket,
+// keyed by timestamp, so Stats can range-scan them.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens a BoltStore backed by the file at u.Path, e.g.
+// bolt:///var/lib/goshorty.db.
+func NewBoltStore(u *url.URL) (*BoltStore, error) {
+	db, err := bolt.Open(u.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, createdBucket, expiresBucket, ownerBucket, hitsBucket, sourcesBucket, idempotentBucket, hooksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Create(destination string, opts CreateOptions) (*Record, error) {
+	if opts.Id != "" {
+		return s.createWithId(opts.Id, destination, opts.ExpiresAt, opts.Owner)
+	}
+
+	for attempt := 0; attempt < maxCreateAttempts; attempt++ {
+		id := generateId(opts.Length, opts.Regex)
+		if id == "" {
+			break
+		}
+
+		record, err := s.createWithId(id, destination, opts.ExpiresAt, opts.Owner)
+		if err == ErrExists {
+			continue
+		}
+		return record, err
+	}
+
+	return nil, ErrExists
+}
+
+func (s *BoltStore) createWithId(id, destination string, expiresAt time.Time, owner string) (*Record, error) {
+	created := time.Now()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		if urls.Get([]byte(id)) != nil {
+			return ErrExists
+		}
+		if err := urls.Put([]byte(id), []byte(destination)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(createdBucket).Put([]byte(id), []byte(strconv.FormatInt(created.Unix(), 10))); err != nil {
+			return err
+		}
+		if !expiresAt.IsZero() {
+			if err := tx.Bucket(expiresBucket).Put([]byte(id), []byte(strconv.FormatInt(expiresAt.Unix(), 10))); err != nil {
+				return err
+			}
+		}
+		if owner == "" {
+			return nil
+		}
+		return tx.Bucket(ownerBucket).Put([]byte(id), []byte(owner))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{Id: id, Destination: destination, Created: created, ExpiresAt: expiresAt, Owner: owner}, nil
+}
+
+func (s *BoltStore) Get(id string) (*Record, error) {
+	var record *Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		destination := tx.Bucket(urlsBucket).Get([]byte(id))
+		if destination == nil {
+			return ErrNotFound
+		}
+
+		created := time.Time{}
+		if ts := tx.Bucket(createdBucket).Get([]byte(id)); ts != nil {
+			if unix, err := strconv.ParseInt(string(ts), 10, 64); err == nil {
+				created = time.Unix(unix, 0)
+			}
+		}
+
+		expiresAt := time.Time{}
+		if ts := tx.Bucket(expiresBucket).Get([]byte(id)); ts != nil {
+			if unix, err := strconv.ParseInt(string(ts), 10, 64); err == nil {
+				expiresAt = time.Unix(unix, 0)
+			}
+		}
+
+		owner := ""
+		if raw := tx.Bucket(ownerBucket).Get([]byte(id)); raw != nil {
+			owner = string(raw)
+		}
+
+		record = &Record{Id: id, Destination: string(destination), Created: created, ExpiresAt: expiresAt, Owner: owner}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *BoltStore) Hit(id string, at time.Time, source string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		hits, err := tx.Bucket(hitsBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(at.UnixNano()))
+		if err := hits.Put(key, []byte{}); err != nil {
+			return err
+		}
+
+		if source == "" {
+			return nil
+		}
+
+		sources, err := tx.Bucket(sourcesBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		count := int64(0)
+		if raw := sources.Get([]byte(source)); raw != nil {
+			count, _ = strconv.ParseInt(string(raw), 10, 64)
+		}
+		return sources.Put([]byte(source), []byte(strconv.FormatInt(count+1, 10)))
+	})
+}
+
+func (s *BoltStore) Hits(id string) (int64, error) {
+	return s.Stats(id, BucketAll)
+}
+
+func (s *BoltStore) Stats(id string, bucket Bucket) (int64, error) {
+	var count int64
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		hits := tx.Bucket(hitsBucket).Bucket([]byte(id))
+		if hits == nil {
+			return nil
+		}
+
+		since := bucketStart(bucket)
+		c := hits.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			ts := time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+			if !ts.Before(since) {
+				count++
+			}
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (s *BoltStore) Sources(id string) ([]Source, error) {
+	var sources []Source
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sourcesBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			n, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return nil
+			}
+			sources = append(sources, Source{Key: string(k), Count: n})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortSourcesDesc(sources)
+	return sources, nil
+}
+
+func (s *BoltStore) GetIdempotent(key string) (*Record, error) {
+	var id string
+	var found bool
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotentBucket)
+		raw := bucket.Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+
+		parts := strings.SplitN(string(raw), "|", 2)
+		expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil
+		}
+		if time.Now().After(time.Unix(expiresAt, 0)) {
+			return bucket.Delete([]byte(key))
+		}
+
+		id = parts[0]
+		found = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return s.Get(id)
+}
+
+func (s *BoltStore) PutIdempotent(key string, record *Record, ttl time.Duration) error {
+	value := record.Id + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idempotentBucket).Put([]byte(key), []byte(value))
+	})
+}
+
+func (s *BoltStore) ListByOwner(owner string) ([]Record, error) {
+	var records []Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ownerBucket).ForEach(func(id, raw []byte) error {
+			if string(raw) != owner {
+				return nil
+			}
+
+			destination := tx.Bucket(urlsBucket).Get(id)
+			if destination == nil {
+				return nil
+			}
+
+			created := time.Time{}
+			if ts := tx.Bucket(createdBucket).Get(id); ts != nil {
+				if unix, err := strconv.ParseInt(string(ts), 10, 64); err == nil {
+					created = time.Unix(unix, 0)
+				}
+			}
+
+			expiresAt := time.Time{}
+			if ts := tx.Bucket(expiresBucket).Get(id); ts != nil {
+				if unix, err := strconv.ParseInt(string(ts), 10, 64); err == nil {
+					expiresAt = time.Unix(unix, 0)
+				}
+			}
+
+			records = append(records, Record{Id: string(id), Destination: string(destination), Created: created, ExpiresAt: expiresAt, Owner: owner})
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+func (s *BoltStore) AddWebhook(hook Webhook) (*Webhook, error) {
+	hook.Id = randomString(16)
+	value, err := json.Marshal(webhookValue{Url: hook.Url, Secret: hook.Secret})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(hooksBucket).CreateBucketIfNotExists([]byte(hook.UrlId))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(hook.Id), value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &hook, nil
+}
+
+func (s *BoltStore) Webhooks(id string) ([]Webhook, error) {
+	var hooks []Webhook
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(hooksBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(hookId, raw []byte) error {
+			var value webhookValue
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil
+			}
+			hooks = append(hooks, Webhook{Id: string(hookId), UrlId: id, Url: value.Url, Secret: value.Secret})
+			return nil
+		})
+	})
+
+	return hooks, err
+}
+
+func (s *BoltStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, createdBucket, expiresBucket, ownerBucket} {
+			if err := tx.Bucket(name).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		if hits := tx.Bucket(hitsBucket).Bucket([]byte(id)); hits != nil {
+			if err := tx.Bucket(hitsBucket).DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+		if sources := tx.Bucket(sourcesBucket).Bucket([]byte(id)); sources != nil {
+			if err := tx.Bucket(sourcesBucket).DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+		if hooks := tx.Bucket(hooksBucket).Bucket([]byte(id)); hooks != nil {
+			if err := tx.Bucket(hooksBucket).DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Ping() error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"math/rand"
+	"regexp"
+)
+
+// alphabet is the character set shortcodes are drawn from. It is a
+// superset of the default `-regex` flag so generated codes still need
+// validating against the caller's actual pattern.
+const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// maxCreateAttempts bounds how many random shortcodes Create will try
+// before giving up on a collision.
+const maxCreateAttempts = 10
+
+// generateId returns a random shortcode of the given length matching
+// regex, or an empty string if it could not produce one after a
+// handful of attempts.
+func generateId(length int, pattern string) string {
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		re = nil
+	}
+
+	for attempt := 0; attempt < maxCreateAttempts*4; attempt++ {
+		id := randomString(length)
+		if re == nil || re.MatchString(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+func randomString(length int) string {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(b)
+}
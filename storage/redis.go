@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisStore is the original goshorty driver, suited to high-traffic
+// deployments that already run Redis. Hit timestamps are kept in a
+// per-shortcode sorted set so Stats can bucket them without a
+// secondary index.
+type RedisStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedisStore opens a RedisStore against u, e.g. redis://host:6379.
+// u.Path, if present, is used as the key prefix instead of the
+// default "goshorty:".
+func NewRedisStore(u *url.URL) (*RedisStore, error) {
+	prefix := "goshorty:"
+	if u.Path != "" && u.Path != "/" {
+		prefix = u.Path[1:]
+	}
+
+	pool := &redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", u.Host)
+		},
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return nil, err
+	}
+
+	return &RedisStore{pool: pool, prefix: prefix}, nil
+}
+
+func (s *RedisStore) key(parts ...string) string {
+	key := s.prefix
+	for _, part := range parts {
+		key += part
+	}
+	return key
+}
+
+func (s *RedisStore) Create(destination string, opts CreateOptions) (*Record, error) {
+	if opts.Id != "" {
+		return s.createWithId(opts.Id, destination, opts.ExpiresAt, opts.Owner)
+	}
+
+	for attempt := 0; attempt < maxCreateAttempts; attempt++ {
+		id := generateId(opts.Length, opts.Regex)
+		if id == "" {
+			break
+		}
+
+		record, err := s.createWithId(id, destination, opts.ExpiresAt, opts.Owner)
+		if err == ErrExists {
+			continue
+		}
+		return record, err
+	}
+
+	return nil, ErrExists
+}
+
+// createWithId persists destination under id, failing with ErrExists
+// if it is already taken.
+func (s *RedisStore) createWithId(id, destination string, expiresAt time.Time, owner string) (*Record, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	created := time.Now()
+	ok, err := redis.Bool(conn.Do("SETNX", s.key("url:", id), destination))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrExists
+	}
+
+	if _, err := conn.Do("SET", s.key("created:", id), created.Unix()); err != nil {
+		return nil, err
+	}
+
+	if !expiresAt.IsZero() {
+		if _, err := conn.Do("SET", s.key("expires:", id), expiresAt.Unix()); err != nil {
+			return nil, err
+		}
+	}
+
+	if owner != "" {
+		if _, err := conn.Do("SET", s.key("owner:", id), owner); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Do("SADD", s.key("owned:", owner), id); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Record{Id: id, Destination: destination, Created: created, ExpiresAt: expiresAt, Owner: owner}, nil
+}
+
+func (s *RedisStore) Get(id string) (*Record, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	destination, err := redis.String(conn.Do("GET", s.key("url:", id)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	created := time.Time{}
+	if ts, err := redis.Int64(conn.Do("GET", s.key("created:", id))); err == nil {
+		created = time.Unix(ts, 0)
+	}
+
+	expiresAt := time.Time{}
+	if ts, err := redis.Int64(conn.Do("GET", s.key("expires:", id))); err == nil {
+		expiresAt = time.Unix(ts, 0)
+	}
+
+	owner, _ := redis.String(conn.Do("GET", s.key("owner:", id)))
+
+	return &Record{Id: id, Destination: destination, Created: created, ExpiresAt: expiresAt, Owner: owner}, nil
+}
+
+func (s *RedisStore) Hit(id string, at time.Time, source string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	member := strconv.FormatInt(at.UnixNano(), 10)
+	if _, err := conn.Do("ZADD", s.key("hits:", id), at.Unix(), member); err != nil {
+		return err
+	}
+
+	if source != "" {
+		if _, err := conn.Do("HINCRBY", s.key("sources:", id), source, 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *RedisStore) Hits(id string) (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return redis.Int64(conn.Do("ZCARD", s.key("hits:", id)))
+}
+
+func (s *RedisStore) Stats(id string, bucket Bucket) (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	if bucket == BucketAll {
+		return s.Hits(id)
+	}
+
+	since := bucketStart(bucket)
+	return redis.Int64(conn.Do("ZCOUNT", s.key("hits:", id), since.Unix(), "+inf"))
+}
+
+func (s *RedisStore) Sources(id string) ([]Source, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", s.key("sources:", id)))
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]Source, 0, len(values))
+	for key, count := range values {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			continue
+		}
+		sources = append(sources, Source{Key: key, Count: n})
+	}
+
+	sortSourcesDesc(sources)
+	return sources, nil
+}
+
+func (s *RedisStore) GetIdempotent(key string) (*Record, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	id, err := redis.String(conn.Do("GET", s.key("idempotent:", key)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s.Get(id)
+}
+
+func (s *RedisStore) PutIdempotent(key string, record *Record, ttl time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", s.key("idempotent:", key), record.Id, "EX", int(ttl.Seconds()))
+	return err
+}
+
+func (s *RedisStore) ListByOwner(owner string) ([]Record, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", s.key("owned:", owner)))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(ids))
+	for _, id := range ids {
+		record, err := s.Get(id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+
+	return records, nil
+}
+
+func (s *RedisStore) Delete(id string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	owner, _ := redis.String(conn.Do("GET", s.key("owner:", id)))
+
+	_, err := conn.Do("DEL",
+		s.key("url:", id), s.key("created:", id), s.key("expires:", id),
+		s.key("owner:", id), s.key("hits:", id), s.key("sources:", id), s.key("hooks:", id),
+	)
+	if err != nil {
+		return err
+	}
+
+	if owner != "" {
+		_, err = conn.Do("SREM", s.key("owned:", owner), id)
+	}
+	return err
+}
+
+// webhookValue is how a Webhook's Url/Secret are stored in the
+// per-shortcode hooks hash, keyed by the webhook's Id.
+type webhookValue struct {
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+func (s *RedisStore) AddWebhook(hook Webhook) (*Webhook, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	hook.Id = randomString(16)
+	value, err := json.Marshal(webhookValue{Url: hook.Url, Secret: hook.Secret})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Do("HSET", s.key("hooks:", hook.UrlId), hook.Id, value); err != nil {
+		return nil, err
+	}
+
+	return &hook, nil
+}
+
+func (s *RedisStore) Webhooks(id string) ([]Webhook, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.StringMap(conn.Do("HGETALL", s.key("hooks:", id)))
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]Webhook, 0, len(fields))
+	for hookId, raw := range fields {
+		var value webhookValue
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			continue
+		}
+		hooks = append(hooks, Webhook{Id: hookId, UrlId: id, Url: value.Url, Secret: value.Secret})
+	}
+
+	return hooks, nil
+}
+
+func (s *RedisStore) Ping() error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	return err
+}
+
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
+
+// bucketStart returns the earliest time a hit counts towards bucket,
+// relative to now.
+func bucketStart(bucket Bucket) time.Time {
+	now := time.Now()
+	switch bucket {
+	case BucketHour:
+		return now.Add(-time.Hour)
+	case BucketDay:
+		return now.Add(-24 * time.Hour)
+	case BucketWeek:
+		return now.Add(-7 * 24 * time.Hour)
+	case BucketMonth:
+		return now.Add(-30 * 24 * time.Hour)
+	case BucketYear:
+		return now.Add(-365 * 24 * time.Hour)
+	default:
+		return time.Time{}
+	}
+}
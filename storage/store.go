@@ -0,0 +1,160 @@
+// Package storage defines the persistence interface goshorty uses to
+// create, look up and record hits against shortened URLs, independent
+// of the database backing it.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// ErrNotFound is returned when a shortcode has no associated record.
+var ErrNotFound = errors.New("storage: shortcode not found")
+
+// ErrExists is returned when a shortcode is already taken.
+var ErrExists = errors.New("storage: shortcode already exists")
+
+// Bucket identifies the time window hit counts are aggregated over by
+// Stats.
+type Bucket string
+
+const (
+	BucketHour  Bucket = "hour"
+	BucketDay   Bucket = "day"
+	BucketWeek  Bucket = "week"
+	BucketMonth Bucket = "month"
+	BucketYear  Bucket = "year"
+	BucketAll   Bucket = "all"
+)
+
+// Record is a persisted shortcode -> destination mapping.
+type Record struct {
+	Id          string
+	Destination string
+	Created     time.Time
+	ExpiresAt   time.Time // zero means the record never expires
+	Owner       string    // token that created it, or "" if anonymous
+}
+
+// Expired reports whether r had an expiry set and it has passed.
+func (r *Record) Expired() bool {
+	return !r.ExpiresAt.IsZero() && time.Now().After(r.ExpiresAt)
+}
+
+// CreateOptions controls how Store.Create picks a shortcode and
+// whether the record it creates expires.
+type CreateOptions struct {
+	// Id reserves a specific shortcode (a custom alias), failing with
+	// ErrExists if it is taken. Leave empty to auto-generate one of
+	// Length characters matching Regex.
+	Id     string
+	Length int
+	Regex  string
+
+	// ExpiresAt, if non-zero, causes Get to still return the record
+	// but Record.Expired to report true once it has passed.
+	ExpiresAt time.Time
+
+	// Owner, if set, is the token that created the record, recorded
+	// so ListByOwner and the delete-on-owner-or-admin check can work.
+	Owner string
+}
+
+// Source is an aggregated hit count for a single referrer or country.
+type Source struct {
+	Key   string
+	Count int64
+}
+
+// Webhook is a per-shortcode subscription notified of every hit.
+type Webhook struct {
+	Id     string // opaque identifier, generated by AddWebhook
+	UrlId  string
+	Url    string
+	Secret string
+}
+
+// Store is implemented by each persistence driver (Redis, BoltDB,
+// Postgres, ...). It covers everything the handlers need: creating
+// shortcodes, serving redirects, recording hits and rendering stats.
+type Store interface {
+	// Create persists destination under opts.Id, or a freshly
+	// generated shortcode matching opts.Regex when opts.Id is empty,
+	// failing with ErrExists on collision.
+	Create(destination string, opts CreateOptions) (*Record, error)
+
+	// Get looks up a shortcode, returning ErrNotFound if it is
+	// unknown. It is returned even if expired; callers check
+	// Record.Expired.
+	Get(id string) (*Record, error)
+
+	// Hit records a single visit to id at the given time, tagged with
+	// source (e.g. a referrer host or country) for use by Sources.
+	Hit(id string, at time.Time, source string) error
+
+	// Hits returns the all-time hit count for id.
+	Hits(id string) (int64, error)
+
+	// Stats returns the hit count for id within the given bucket.
+	Stats(id string, bucket Bucket) (int64, error)
+
+	// Sources returns hit counts for id grouped by source, sorted
+	// descending by count.
+	Sources(id string) ([]Source, error)
+
+	// GetIdempotent returns the record previously created under key
+	// by PutIdempotent, or ErrNotFound if key is unseen or expired.
+	GetIdempotent(key string) (*Record, error)
+
+	// PutIdempotent remembers that key produced record, for ttl.
+	PutIdempotent(key string, record *Record, ttl time.Duration) error
+
+	// ListByOwner returns every record created with Owner == owner.
+	ListByOwner(owner string) ([]Record, error)
+
+	// Delete removes id. Deleting an unknown id is not an error.
+	Delete(id string) error
+
+	// AddWebhook registers hook against hook.UrlId, assigning it an Id.
+	AddWebhook(hook Webhook) (*Webhook, error)
+
+	// Webhooks returns every webhook registered against id.
+	Webhooks(id string) ([]Webhook, error)
+
+	// Ping checks that the backing database is reachable, for use by
+	// readiness probes.
+	Ping() error
+
+	// Close releases any resources held by the driver.
+	Close() error
+}
+
+// New opens a Store for rawUrl, dispatching on its scheme: redis://,
+// bolt:// or postgres://.
+func New(rawUrl string) (Store, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "redis", "":
+		return NewRedisStore(u)
+	case "bolt":
+		return NewBoltStore(u)
+	case "postgres":
+		return NewPostgresStore(u)
+	default:
+		return nil, fmt.Errorf("storage: unsupported scheme %q", u.Scheme)
+	}
+}
+
+// sortSourcesDesc orders sources by Count, highest first.
+func sortSourcesDesc(sources []Source) {
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Count > sources[j].Count
+	})
+}
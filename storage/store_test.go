@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestStores returns every driver worth exercising in this
+// environment. BoltStore needs only a temp file, so it always runs;
+// Redis and Postgres are skipped unless their test DSNs are reachable.
+func newTestStores(t *testing.T) map[string]Store {
+	stores := map[string]Store{}
+
+	boltStore, err := NewBoltStore(mustParse(t, "bolt://"+t.TempDir()+"/goshorty.db"))
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	stores["bolt"] = boltStore
+
+	if redisStore, err := NewRedisStore(mustParse(t, "redis://localhost:6379")); err == nil {
+		stores["redis"] = redisStore
+	} else {
+		t.Logf("skipping redis driver: %v", err)
+	}
+
+	if pgStore, err := NewPostgresStore(mustParse(t, "postgres://localhost/goshorty_test?sslmode=disable")); err == nil {
+		stores["postgres"] = pgStore
+	} else {
+		t.Logf("skipping postgres driver: %v", err)
+	}
+
+	return stores
+}
+
+func mustParse(t *testing.T, rawUrl string) *url.URL {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("parse %q: %v", rawUrl, err)
+	}
+	return u
+}
+
+func TestStores_CreateAndGet(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			record, err := store.Create("http://example.com/a", CreateOptions{Length: 5, Regex: "[A-Za-z0-9]{5}"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if len(record.Id) != 5 {
+				t.Fatalf("expected a 5 character id, got %q", record.Id)
+			}
+
+			got, err := store.Get(record.Id)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Destination != "http://example.com/a" {
+				t.Fatalf("expected destination to round-trip, got %q", got.Destination)
+			}
+		})
+	}
+}
+
+func TestStores_GetMissing(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			if _, err := store.Get("nosuch"); err != ErrNotFound {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStores_HitBucketsAndSources(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			record, err := store.Create("http://example.com/b", CreateOptions{Length: 5, Regex: "[A-Za-z0-9]{5}"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			now := time.Now()
+			if err := store.Hit(record.Id, now, "twitter.com"); err != nil {
+				t.Fatalf("Hit: %v", err)
+			}
+			if err := store.Hit(record.Id, now.Add(-48*time.Hour), "twitter.com"); err != nil {
+				t.Fatalf("Hit: %v", err)
+			}
+
+			hits, err := store.Hits(record.Id)
+			if err != nil {
+				t.Fatalf("Hits: %v", err)
+			}
+			if hits != 2 {
+				t.Fatalf("expected 2 total hits, got %d", hits)
+			}
+
+			dayHits, err := store.Stats(record.Id, BucketDay)
+			if err != nil {
+				t.Fatalf("Stats: %v", err)
+			}
+			if dayHits != 1 {
+				t.Fatalf("expected 1 hit in the last day, got %d", dayHits)
+			}
+
+			sources, err := store.Sources(record.Id)
+			if err != nil {
+				t.Fatalf("Sources: %v", err)
+			}
+			if len(sources) != 1 || sources[0].Key != "twitter.com" || sources[0].Count != 2 {
+				t.Fatalf("expected twitter.com:2, got %+v", sources)
+			}
+		})
+	}
+}
+
+func TestStores_CustomAliasCollision(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			if _, err := store.Create("http://example.com/c", CreateOptions{Id: "mine"}); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if _, err := store.Create("http://example.com/d", CreateOptions{Id: "mine"}); err != ErrExists {
+				t.Fatalf("expected ErrExists on a taken alias, got %v", err)
+			}
+		})
+	}
+}
+
+// TestStores_CustomAliasCollisionSameDestination guards against a
+// regression where a collision on a custom alias went undetected
+// because the driver compared the stored destination to the new
+// caller's instead of checking whether the insert actually happened.
+func TestStores_CustomAliasCollisionSameDestination(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			first, err := store.Create("http://example.com/c", CreateOptions{Id: "ours", Owner: "alice"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if _, err := store.Create("http://example.com/c", CreateOptions{Id: "ours", Owner: "bob"}); err != ErrExists {
+				t.Fatalf("expected ErrExists on a taken alias with a matching destination, got %v", err)
+			}
+
+			got, err := store.Get("ours")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Owner != first.Owner {
+				t.Fatalf("collision must not reassign ownership: expected owner %q, got %q", first.Owner, got.Owner)
+			}
+		})
+	}
+}
+
+func TestStores_ExpiresAt(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			past := time.Now().Add(-time.Hour)
+			record, err := store.Create("http://example.com/e", CreateOptions{Length: 5, Regex: "[A-Za-z0-9]{5}", ExpiresAt: past})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			got, err := store.Get(record.Id)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if !got.Expired() {
+				t.Fatalf("expected record with a past ExpiresAt to report Expired")
+			}
+		})
+	}
+}
+
+func TestStores_Idempotent(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			if _, err := store.GetIdempotent("missing-key"); err != ErrNotFound {
+				t.Fatalf("expected ErrNotFound for an unseen key, got %v", err)
+			}
+
+			record, err := store.Create("http://example.com/f", CreateOptions{Length: 5, Regex: "[A-Za-z0-9]{5}"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if err := store.PutIdempotent("my-key", record, time.Hour); err != nil {
+				t.Fatalf("PutIdempotent: %v", err)
+			}
+
+			got, err := store.GetIdempotent("my-key")
+			if err != nil {
+				t.Fatalf("GetIdempotent: %v", err)
+			}
+			if got.Id != record.Id {
+				t.Fatalf("expected GetIdempotent to return the remembered record, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestStores_ListByOwnerAndDelete(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			record, err := store.Create("http://example.com/g", CreateOptions{Length: 5, Regex: "[A-Za-z0-9]{5}", Owner: "token-a"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			owned, err := store.ListByOwner("token-a")
+			if err != nil {
+				t.Fatalf("ListByOwner: %v", err)
+			}
+			if len(owned) != 1 || owned[0].Id != record.Id {
+				t.Fatalf("expected ListByOwner to return the created record, got %+v", owned)
+			}
+
+			if err := store.Delete(record.Id); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+
+			if _, err := store.Get(record.Id); err != ErrNotFound {
+				t.Fatalf("expected ErrNotFound after Delete, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStores_Webhooks(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			record, err := store.Create("http://example.com/h", CreateOptions{Length: 5, Regex: "[A-Za-z0-9]{5}"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			hook, err := store.AddWebhook(Webhook{UrlId: record.Id, Url: "http://example.com/hook", Secret: "shh"})
+			if err != nil {
+				t.Fatalf("AddWebhook: %v", err)
+			}
+			if hook.Id == "" {
+				t.Fatalf("expected AddWebhook to assign an Id")
+			}
+
+			hooks, err := store.Webhooks(record.Id)
+			if err != nil {
+				t.Fatalf("Webhooks: %v", err)
+			}
+			if len(hooks) != 1 || hooks[0].Url != "http://example.com/hook" || hooks[0].Secret != "shh" {
+				t.Fatalf("expected the registered webhook to be returned, got %+v", hooks)
+			}
+
+			if err := store.Delete(record.Id); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+
+			hooks, err = store.Webhooks(record.Id)
+			if err != nil {
+				t.Fatalf("Webhooks after Delete: %v", err)
+			}
+			if len(hooks) != 0 {
+				t.Fatalf("expected no webhooks after Delete, got %+v", hooks)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used to start the child spans around storage calls and
+// the async GosUrl.Hit goroutine. It is a no-op until setupTracing
+// installs a real TracerProvider.
+var tracer = otel.Tracer("github.com/quikly/goshorty")
+
+// setupTracing configures the global TracerProvider to export spans to
+// otlpEndpoint, or leaves tracing a no-op if it is empty.
+func setupTracing(otlpEndpoint string) (func(context.Context) error, error) {
+	if otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpoint(otlpEndpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("goshorty"))),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("github.com/quikly/goshorty")
+
+	return provider.Shutdown, nil
+}
+
+// traceMiddleware wraps router with OpenTelemetry HTTP instrumentation
+// so every request produces a span; handlers add goshorty-specific
+// attributes to it via annotateSpan.
+func traceMiddleware(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "goshorty.request")
+}
+
+// annotateSpan sets the goshorty.id and goshorty.destination_host
+// attributes gddo-style handlers add once they know which shortcode a
+// request resolved to.
+func annotateSpan(ctx context.Context, id, destinationHost string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("goshorty.id", id),
+		attribute.String("goshorty.destination_host", destinationHost),
+	)
+}
+
+// traceStorage runs fn inside a child span named "storage."+op.
+func traceStorage(ctx context.Context, op string, fn func(context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "storage."+op)
+	defer span.End()
+	return fn(ctx)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/quikly/goshorty/storage"
+)
+
+// GosUrl is a shortened URL together with the persistence operations
+// available on it. It is a thin wrapper around the configured
+// storage.Store so handlers in app.go don't need to know which driver
+// is in use.
+type GosUrl struct {
+	Id          string
+	Destination string
+	Created     time.Time
+	ExpiresAt   time.Time
+	Owner       string
+}
+
+// NewUrl shortens destination, generating a fresh code of
+// settings.UrlLength characters matching the configured -regex.
+func NewUrl(destination string) (*GosUrl, error) {
+	return NewUrlWithOptions(destination, storage.CreateOptions{})
+}
+
+// NewUrlWithOptions shortens destination, reserving opts.Id as a
+// custom alias when given and defaulting Length/Regex from settings
+// otherwise. It fails with storage.ErrExists if opts.Id is taken.
+func NewUrlWithOptions(destination string, opts storage.CreateOptions) (*GosUrl, error) {
+	if opts.Id == "" {
+		if opts.Length == 0 {
+			opts.Length = settings.UrlLength
+		}
+		if opts.Regex == "" {
+			opts.Regex = settings.Regex
+		}
+	}
+
+	var record *storage.Record
+	err := observeStorage("create", func() error {
+		var err error
+		record, err = store.Create(destination, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	shortensCreated.Inc()
+
+	return newGosUrl(record), nil
+}
+
+// GetUrl looks up id, returning a nil *GosUrl (and nil error) when it
+// is unknown so callers can distinguish "not found" from failure.
+// Expired urls are still returned; callers check GosUrl.Expired.
+func GetUrl(id string) (*GosUrl, error) {
+	record, err := store.Get(id)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return newGosUrl(record), nil
+}
+
+// GetIdempotentUrl returns the url previously created under key via
+// RememberIdempotent, or nil if key is unseen or its entry expired.
+func GetIdempotentUrl(key string) (*GosUrl, error) {
+	record, err := store.GetIdempotent(key)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return newGosUrl(record), nil
+}
+
+// idempotencyWindow is how long an Idempotency-Key is remembered for.
+const idempotencyWindow = 24 * time.Hour
+
+// RememberIdempotent associates key with u for idempotencyWindow, so a
+// retried request with the same key returns u instead of creating a
+// second shortcode.
+func RememberIdempotent(key string, u *GosUrl) error {
+	return store.PutIdempotent(key, &storage.Record{
+		Id:          u.Id,
+		Destination: u.Destination,
+		Created:     u.Created,
+		ExpiresAt:   u.ExpiresAt,
+		Owner:       u.Owner,
+	}, idempotencyWindow)
+}
+
+// Expired reports whether this url had an expiry set and it has
+// passed.
+func (u *GosUrl) Expired() bool {
+	return !u.ExpiresAt.IsZero() && time.Now().After(u.ExpiresAt)
+}
+
+// ListUrlsByOwner returns every url created by owner.
+func ListUrlsByOwner(owner string) ([]*GosUrl, error) {
+	records, err := store.ListByOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]*GosUrl, len(records))
+	for i := range records {
+		urls[i] = newGosUrl(&records[i])
+	}
+	return urls, nil
+}
+
+// DeleteUrl removes id. Deleting an unknown id is not an error.
+func DeleteUrl(id string) error {
+	return store.Delete(id)
+}
+
+func newGosUrl(record *storage.Record) *GosUrl {
+	return &GosUrl{
+		Id:          record.Id,
+		Destination: record.Destination,
+		Created:     record.Created,
+		ExpiresAt:   record.ExpiresAt,
+		Owner:       record.Owner,
+	}
+}
+
+// ParsedRequest is the subset of an inbound request's metadata that
+// gets recorded against a hit, as produced by requestParser.Parse.
+type ParsedRequest struct {
+	Country   string
+	Referrer  string
+	UserAgent string
+}
+
+// Hit records a single visit to this url, then notifies any /events
+// subscribers and registered webhooks.
+func (u *GosUrl) Hit(request *ParsedRequest) error {
+	return u.HitContext(context.Background(), request)
+}
+
+// HitContext is Hit with an explicit context, so the storage call can
+// be traced as a child of the caller's span (RedirectHandler queues
+// hits via enqueueHit, and a hit worker records them detached from
+// the request context).
+func (u *GosUrl) HitContext(ctx context.Context, request *ParsedRequest) error {
+	source := ""
+	event := hitEvent{Ts: time.Now()}
+	if request != nil {
+		source = request.Country
+		event.Country = request.Country
+		event.Referrer = request.Referrer
+		event.UserAgent = request.UserAgent
+	}
+
+	err := traceStorage(ctx, "hit", func(context.Context) error {
+		return observeStorage("hit", func() error {
+			return store.Hit(u.Id, event.Ts, source)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	hub.Publish(u.Id, event)
+	deliverWebhooks(u.Id, event)
+	return nil
+}
+
+// Hits returns the all-time hit count for this url.
+func (u *GosUrl) Hits() (int64, error) {
+	return store.Hits(u.Id)
+}
+
+// Stats returns the hit count for this url within the named bucket
+// (hour|day|week|month|year|all).
+func (u *GosUrl) Stats(bucket string) (int64, error) {
+	return store.Stats(u.Id, storage.Bucket(bucket))
+}
+
+// Sources returns hit counts for this url grouped by source (country),
+// sorted descending by count. unique is accepted for API compatibility
+// but not yet implemented by any driver.
+func (u *GosUrl) Sources(unique bool) ([]storage.Source, error) {
+	return store.Sources(u.Id)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/quikly/goshorty/storage"
+)
+
+// AddWebhookRequest is the body POSTed to /api/v1/url/{id}/hooks.
+type AddWebhookRequest struct {
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// AddWebhookHandler registers a webhook that gosUrl.Hit will notify of
+// every future hit against this shortcode.
+func AddWebhookHandler(resp http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+
+	gosUrl, err := GetUrl(vars["id"])
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	} else if gosUrl == nil {
+		return httpErrorf(http.StatusNotFound, "No URL was found with that goshorty code")
+	}
+
+	var message AddWebhookRequest
+	if err := json.NewDecoder(req.Body).Decode(&message); err != nil {
+		return httpErrorf(http.StatusBadRequest, "%s", err)
+	}
+	if message.Url == "" || message.Secret == "" {
+		return httpErrorf(http.StatusBadRequest, "url and secret are both required")
+	}
+
+	hook, err := store.AddWebhook(storage.Webhook{UrlId: gosUrl.Id, Url: message.Url, Secret: message.Secret})
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "%s", err)
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(resp).Encode(hook)
+}
+
+// webhookWorkers bounds how many webhook deliveries run concurrently,
+// so a burst of hits against a popular shortcode can't open an
+// unbounded number of outbound connections.
+const webhookWorkers = 8
+
+// webhookDeliveries is the queue deliverWebhooks feeds and the worker
+// pool below drains.
+var webhookDeliveries = make(chan webhookDelivery, 1024)
+
+type webhookDelivery struct {
+	hook    storage.Webhook
+	payload []byte
+}
+
+func init() {
+	for i := 0; i < webhookWorkers; i++ {
+		go webhookWorker()
+	}
+}
+
+func webhookWorker() {
+	for delivery := range webhookDeliveries {
+		sendWebhook(delivery)
+	}
+}
+
+// webhookRetries and webhookBackoff bound how hard a single delivery
+// is retried before it's given up on.
+const webhookRetries = 3
+
+var webhookBackoff = time.Second
+
+func sendWebhook(delivery webhookDelivery) {
+	signature := signWebhookPayload(delivery.hook.Secret, delivery.payload)
+
+	backoff := webhookBackoff
+	for attempt := 0; attempt < webhookRetries; attempt++ {
+		req, err := http.NewRequest("POST", delivery.hook.Url, bytes.NewReader(delivery.payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Goshorty-Signature", signature)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode < 500 {
+					return
+				}
+			}
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// signWebhookPayload returns the hex-encoded HMAC-SHA256 of payload
+// under secret, as sent in the X-Goshorty-Signature header.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhooks enqueues event, marshaled as its webhook payload, to
+// every webhook registered against id. Queue-full deliveries are
+// dropped rather than blocking the hit that triggered them.
+func deliverWebhooks(id string, event hitEvent) {
+	hooks, err := store.Webhooks(id)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	for _, hook := range hooks {
+		select {
+		case webhookDeliveries <- webhookDelivery{hook: hook, payload: payload}:
+		default:
+		}
+	}
+}